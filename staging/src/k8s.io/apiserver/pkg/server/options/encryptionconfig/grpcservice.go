@@ -0,0 +1,84 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryptionconfig
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"k8s.io/apiserver/pkg/storage/value/encrypt/envelope/kmsv2"
+	kmsv2api "k8s.io/kms/apis/v2alpha1"
+)
+
+// grpcService adapts a dialed KeyManagementServiceClient to kmsv2.Service, the same
+// role backendService plays for in-process backends.
+type grpcService struct {
+	client      kmsv2api.KeyManagementServiceClient
+	callTimeout time.Duration
+}
+
+func newGRPCService(ctx context.Context, endpoint string, callTimeout time.Duration) (kmsv2.Service, error) {
+	conn, err := grpc.DialContext(ctx, endpoint,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(unixDialer),
+		grpc.WithBlock(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcService{
+		client:      kmsv2api.NewKeyManagementServiceClient(conn),
+		callTimeout: callTimeout,
+	}, nil
+}
+
+// unixDialer strips the "unix://" scheme kube-apiserver's EncryptionConfiguration
+// uses for plugin endpoints before handing the address to net.Dial, since grpc's own
+// "unix:" resolver expects a bare path.
+func unixDialer(ctx context.Context, addr string) (net.Conn, error) {
+	addr = strings.TrimPrefix(addr, "unix://")
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", addr)
+}
+
+func (s *grpcService) Status(ctx context.Context) (*kmsv2api.StatusResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.callTimeout)
+	defer cancel()
+	return s.client.Status(ctx, &kmsv2api.StatusRequest{})
+}
+
+func (s *grpcService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*kmsv2api.EncryptResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.callTimeout)
+	defer cancel()
+	return s.client.Encrypt(ctx, &kmsv2api.EncryptRequest{Uid: uid, Plaintext: plaintext})
+}
+
+func (s *grpcService) Decrypt(ctx context.Context, uid string, req *kmsv2api.DecryptRequest) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.callTimeout)
+	defer cancel()
+	req.Uid = uid
+	resp, err := s.client.Decrypt(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package aes transforms values for storage at rest using AES-GCM.
+package aes
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"k8s.io/apiserver/pkg/storage/value"
+)
+
+type gcmTransformer struct {
+	aead cipher.AEAD
+}
+
+// NewGCMTransformer takes the given block cipher and performs encryption and
+// decryption on the given data by sealing/opening it with a randomly generated nonce
+// that is prepended to the ciphertext.
+func NewGCMTransformer(block cipher.Block) value.Transformer {
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(fmt.Sprintf("aes: failed to initialize GCM for a valid AES block cipher: %v", err))
+	}
+	return &gcmTransformer{aead: aead}
+}
+
+func (t *gcmTransformer) TransformFromStorage(_ context.Context, data []byte, dataCtx value.Context) ([]byte, bool, error) {
+	nonceSize := t.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, false, fmt.Errorf("the stored data was shorter than the required size of %d bytes", nonceSize)
+	}
+	nonce, rest := data[:nonceSize], data[nonceSize:]
+	out, err := t.aead.Open(nil, nonce, rest, authenticatedData(dataCtx))
+	return out, false, err
+}
+
+func (t *gcmTransformer) TransformToStorage(_ context.Context, data []byte, dataCtx value.Context) ([]byte, error) {
+	nonce := make([]byte, t.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return t.aead.Seal(nonce, nonce, data, authenticatedData(dataCtx)), nil
+}
+
+func authenticatedData(dataCtx value.Context) []byte {
+	if dataCtx == nil {
+		return nil
+	}
+	return dataCtx.AuthenticatedData()
+}
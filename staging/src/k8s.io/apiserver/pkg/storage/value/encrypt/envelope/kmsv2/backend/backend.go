@@ -0,0 +1,95 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package backend lets a KMSv2 endpoint be served by an in-process key management
+// backend (a PKCS#11 token, a cloud KMS client, a local file) instead of only a
+// unix-socket gRPC plugin.
+package backend
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+
+	kmsv2api "k8s.io/kms/apis/v2alpha1"
+)
+
+// Backend is the interface an in-process KMSv2 backend must implement. It mirrors the
+// gRPC KeyManagementService contract closely enough that encryptionconfig can treat a
+// registered Backend exactly like a Service obtained by dialing a socket.
+type Backend interface {
+	Encrypt(ctx context.Context, uid string, plaintext []byte) (*kmsv2api.EncryptResponse, error)
+	Decrypt(ctx context.Context, uid string, req *kmsv2api.DecryptRequest) ([]byte, error)
+	Status(ctx context.Context) (*kmsv2api.StatusResponse, error)
+}
+
+// Factory constructs a Backend from the scheme-specific remainder of an `endpoint:`
+// value (the part after "<scheme>:").
+type Factory func(ctx context.Context, opaque string) (Backend, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+// Register associates scheme (e.g. "file", "pkcs11", "awskms", "gcpkms") with a
+// Factory. Backends call this from an init(), so selecting one is a matter of
+// importing its package for side effects.
+func Register(scheme string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[scheme]; exists {
+		panic(fmt.Sprintf("backend scheme %q already registered", scheme))
+	}
+	registry[scheme] = factory
+}
+
+// IsRegistered reports whether scheme has a registered Factory, so callers (notably
+// encryptionconfig) can tell a backend endpoint from a unix-socket gRPC endpoint.
+func IsRegistered(scheme string) bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	_, ok := registry[scheme]
+	return ok
+}
+
+// New parses endpoint as "<scheme>:<opaque>" and dispatches to the Factory registered
+// for that scheme.
+func New(ctx context.Context, endpoint string) (Backend, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backend endpoint %q: %w", endpoint, err)
+	}
+	if u.Scheme == "" {
+		return nil, fmt.Errorf("backend endpoint %q has no scheme", endpoint)
+	}
+
+	registryMu.RLock()
+	factory, ok := registry[u.Scheme]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no backend registered for scheme %q", u.Scheme)
+	}
+
+	opaque := u.Opaque
+	if opaque == "" {
+		// endpoints like file:/etc/kek.pem parse with the path in u.Path rather than
+		// u.Opaque because they contain a leading slash.
+		opaque = u.Path
+	}
+	return factory(ctx, opaque)
+}
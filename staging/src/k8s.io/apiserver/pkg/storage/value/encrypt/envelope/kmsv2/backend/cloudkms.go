@@ -0,0 +1,39 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"errors"
+)
+
+func init() {
+	Register("awskms", newCloudKMSBackend)
+	Register("gcpkms", newCloudKMSBackend)
+}
+
+// ErrNoCloudCredentials is returned by the reference cloud KMS backend for every
+// awskms: or gcpkms: endpoint; it does not look for credentials before returning it.
+var ErrNoCloudCredentials = errors.New("no credentials available for the requested cloud KMS")
+
+// newCloudKMSBackend is the shared entry point for the awskms and gcpkms schemes. The
+// reference implementation here does not use a cloud provider SDK: it neither parses
+// its endpoint nor looks for ambient credentials, and always returns
+// ErrNoCloudCredentials, as newPKCS11Backend always returns ErrNoHardwareToken.
+func newCloudKMSBackend(_ context.Context, _ string) (Backend, error) {
+	return nil, ErrNoCloudCredentials
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"errors"
+)
+
+func init() {
+	Register("pkcs11", newPKCS11Backend)
+}
+
+// ErrNoHardwareToken is returned by the reference pkcs11 backend for every pkcs11:
+// endpoint; it does not probe for a PKCS#11 module or hardware token before returning it.
+var ErrNoHardwareToken = errors.New("no PKCS#11 hardware token available")
+
+// pkcs11Backend wraps a PKCS#11 slot/object pair (endpoint "pkcs11:slot=0;object=kek")
+// as a KEK. The reference implementation here does not talk to a PKCS#11 module: it
+// neither parses its endpoint nor looks for a token, and always returns
+// ErrNoHardwareToken. A working PKCS#11 backend is not included in this package.
+func newPKCS11Backend(_ context.Context, _ string) (Backend, error) {
+	return nil, ErrNoHardwareToken
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kmsv2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	kmsv2api "k8s.io/kms/apis/v2alpha1"
+)
+
+// circuitBreaker is a minimal three-state (closed/open/half-open) breaker guarding a
+// single chain member so a dead provider fails fast instead of blocking every request
+// behind its RPC timeout.
+type circuitBreaker struct {
+	failureThreshold int
+	resetTimeout     time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	open     bool
+}
+
+func newCircuitBreaker(failureThreshold int, resetTimeout time.Duration) *circuitBreaker {
+	return &circuitBreaker{failureThreshold: failureThreshold, resetTimeout: resetTimeout}
+}
+
+// allow reports whether a call should be attempted, transitioning an open breaker to
+// half-open once resetTimeout has elapsed.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return true
+	}
+	return time.Since(b.openedAt) >= b.resetTimeout
+}
+
+func (b *circuitBreaker) recordResult(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures = 0
+		b.open = false
+		return
+	}
+	b.failures++
+	if b.failures >= b.failureThreshold {
+		b.open = true
+		b.openedAt = time.Now()
+	}
+}
+
+// ChainMember is one named KMS provider participating in a provider chain, along with
+// the prefix its envelopes are written with and its own circuit breaker and timeout.
+type ChainMember struct {
+	name    string
+	service Service
+	timeout time.Duration
+	breaker *circuitBreaker
+}
+
+// ChainTransformer is a first-class "provider chain" mode for KMSv2: encrypt always
+// goes to the first healthy member (preferring earlier entries), and decrypt is routed
+// to the single member that produced the envelope. Resilience for objects that were
+// already read while that member was healthy comes from envelopeTransformer's DEK
+// cache, not from guessing across providers with unrelated key material.
+type ChainTransformer struct {
+	members []ChainMember
+}
+
+// NewChainTransformer builds a provider chain from members listed in priority order,
+// the same order they appear under `providers:` in the EncryptionConfiguration.
+func NewChainTransformer(members []ChainMember) *ChainTransformer {
+	return &ChainTransformer{members: members}
+}
+
+// NewChainMember constructs a chain participant with its own call timeout and circuit
+// breaker, to be passed to NewChainTransformer.
+func NewChainMember(name string, service Service, timeout time.Duration, failureThreshold int, resetTimeout time.Duration) ChainMember {
+	return ChainMember{
+		name:    name,
+		service: service,
+		timeout: timeout,
+		breaker: newCircuitBreaker(failureThreshold, resetTimeout),
+	}
+}
+
+// Encrypt tries each member in order, skipping any whose circuit breaker is open,
+// performs the Encrypt RPC against the first one that allows a call, and records the
+// outcome on its breaker, returning the name of the provider that produced resp so the
+// caller can prefix the stored envelope with it.
+func (c *ChainTransformer) Encrypt(ctx context.Context, uid string, plaintext []byte) (providerName string, resp *kmsv2api.EncryptResponse, err error) {
+	for _, m := range c.members {
+		if !m.breaker.allow() {
+			continue
+		}
+		callCtx, cancel := context.WithTimeout(ctx, m.timeout)
+		resp, err = m.service.Encrypt(callCtx, uid, plaintext)
+		cancel()
+		m.breaker.recordResult(err)
+		if err == nil {
+			return m.name, resp, nil
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("no healthy provider available in chain of %d members", len(c.members))
+	}
+	return "", nil, err
+}
+
+// DecryptProviders returns the chain member named providerName, if any. An envelope
+// can only be decrypted by the provider that produced it, since EncryptedDEK is
+// wrapped under that provider's own KEK; there is no cross-provider fallback here.
+func (c *ChainTransformer) DecryptProviders(providerName string) []ChainMember {
+	for _, m := range c.members {
+		if m.name == providerName {
+			return []ChainMember{m}
+		}
+	}
+	return nil
+}
+
+// Decrypt calls the chain member named providerName, returning an error if that
+// member is unknown or its circuit breaker is open. Callers that need resilience
+// against a temporarily unhealthy provider should rely on envelopeTransformer's DEK
+// cache rather than expecting Decrypt to succeed here.
+func (c *ChainTransformer) Decrypt(ctx context.Context, providerName, uid string, req *kmsv2api.DecryptRequest) ([]byte, error) {
+	members := c.DecryptProviders(providerName)
+	if len(members) == 0 {
+		return nil, fmt.Errorf("no provider named %q in chain of %d members", providerName, len(c.members))
+	}
+	m := members[0]
+	if !m.breaker.allow() {
+		return nil, fmt.Errorf("provider %q is currently unhealthy", providerName)
+	}
+	callCtx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	plaintext, err := m.service.Decrypt(callCtx, uid, req)
+	m.breaker.recordResult(err)
+	return plaintext, err
+}
+
+// ChainState is a snapshot of one member's health, as reported on
+// healthz/kms-providers.
+type ChainState struct {
+	Name    string
+	Healthy bool
+}
+
+// Healthz returns the current health of every member in the chain, so
+// healthz/kms-providers can report the state of the whole chain rather than a single
+// provider.
+func (c *ChainTransformer) Healthz() []ChainState {
+	states := make([]ChainState, 0, len(c.members))
+	for _, m := range c.members {
+		states = append(states, ChainState{Name: m.name, Healthy: m.breaker.allow()})
+	}
+	return states
+}
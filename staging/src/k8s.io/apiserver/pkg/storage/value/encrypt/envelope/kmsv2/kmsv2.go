@@ -0,0 +1,232 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package kmsv2 transforms values for storage at rest using a Envelope v2 provider
+package kmsv2
+
+import (
+	"context"
+	cryptoaes "crypto/aes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apiserver/pkg/storage/value"
+	aestransformer "k8s.io/apiserver/pkg/storage/value/encrypt/aes"
+	kmstypes "k8s.io/apiserver/pkg/storage/value/encrypt/envelope/kmsv2/v2alpha1"
+	"k8s.io/component-base/tracing"
+	kmsv2api "k8s.io/kms/apis/v2alpha1"
+)
+
+// dekLength is the size in bytes of the per-object data encryption key this
+// transformer generates; 32 bytes selects AES-256 when passed to aes.NewCipher.
+const dekLength = 32
+
+// Service allows encrypting and decrypting data using an external Key Management Service.
+type Service interface {
+	// Decrypt a given bytearray to obtain the original data as bytes.
+	Decrypt(ctx context.Context, uid string, req *kmsv2api.DecryptRequest) ([]byte, error)
+	// Encrypt bytes to a ciphertext.
+	Encrypt(ctx context.Context, uid string, plaintext []byte) (*kmsv2api.EncryptResponse, error)
+	// Status returns the status of the KMS.
+	Status(ctx context.Context) (*kmsv2api.StatusResponse, error)
+}
+
+// envelopeTransformer tracks the keyID currently reported by the KMS plugin's Status
+// response so callers can detect KEK rotation, and caches DEKs by their encrypted form
+// so repeated reads of objects sharing a DEK cost one KMS round trip instead of many.
+type envelopeTransformer struct {
+	providerName    string
+	envelopeService Service
+
+	mu        sync.RWMutex
+	lastKeyID string
+
+	dekCacheMu sync.RWMutex
+	dekCache   map[string][]byte
+}
+
+// NewEnvelopeTransformer constructs a transformer backed by the given KMSv2 service.
+// envelopeService is wrapped with NewInstrumentedService and NewTracingService so that
+// every call this transformer makes is measured and traced without its callers having
+// to know about either concern.
+func NewEnvelopeTransformer(providerName string, envelopeService Service) *envelopeTransformer {
+	return &envelopeTransformer{
+		providerName:    providerName,
+		envelopeService: NewTracingService(providerName, NewInstrumentedService(providerName, envelopeService)),
+		dekCache:        make(map[string][]byte),
+	}
+}
+
+// dekForEncryptedDEK returns the plaintext DEK for encryptedDEK, decrypting and
+// caching it on a miss so that repeated reads of objects sharing the same DEK do not
+// each cost a round trip to the KMS plugin.
+func (t *envelopeTransformer) dekForEncryptedDEK(ctx context.Context, uid string, encryptedDEK []byte) ([]byte, error) {
+	cacheKey := string(encryptedDEK)
+
+	t.dekCacheMu.RLock()
+	dek, ok := t.dekCache[cacheKey]
+	t.dekCacheMu.RUnlock()
+	recordDEKCacheResult(t.providerName, ok)
+	if ok {
+		return dek, nil
+	}
+
+	dek, err := t.envelopeService.Decrypt(ctx, uid, &kmsv2api.DecryptRequest{Uid: uid, Ciphertext: encryptedDEK})
+	if err != nil {
+		return nil, err
+	}
+
+	t.dekCacheMu.Lock()
+	t.dekCache[cacheKey] = dek
+	t.dekCacheMu.Unlock()
+	return dek, nil
+}
+
+// ProviderName returns the name of the provider this transformer is configured for.
+func (t *envelopeTransformer) ProviderName() string {
+	return t.providerName
+}
+
+// CurrentKeyID returns the last keyID observed on a Status response, polling the
+// service if one has not been observed yet.
+func (t *envelopeTransformer) CurrentKeyID(ctx context.Context) (string, error) {
+	t.mu.RLock()
+	keyID := t.lastKeyID
+	t.mu.RUnlock()
+	if keyID != "" {
+		return keyID, nil
+	}
+	return t.refreshKeyID(ctx)
+}
+
+// refreshKeyID polls the plugin's Status response and records the keyID it reports,
+// so that a KEK rotation performed out-of-band by the plugin is observed on the next
+// poll instead of requiring a kube-apiserver restart.
+func (t *envelopeTransformer) refreshKeyID(ctx context.Context) (string, error) {
+	status, err := t.envelopeService.Status(ctx)
+	if err != nil {
+		return "", err
+	}
+	t.mu.Lock()
+	t.lastKeyID = status.KeyID
+	t.mu.Unlock()
+	return status.KeyID, nil
+}
+
+// generateKey returns length bytes read from crypto/rand, for use as a one-time DEK.
+func generateKey(length int) ([]byte, error) {
+	key := make([]byte, length)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// TransformToStorage generates a new DEK, wraps it through the KMS plugin's Encrypt
+// RPC, uses the unwrapped DEK to AES-GCM-encrypt data locally, and returns the
+// marshaled EncryptedObject envelope. Only the wrapped DEK ever leaves this process.
+func (t *envelopeTransformer) TransformToStorage(ctx context.Context, data []byte, dataCtx value.Context) ([]byte, error) {
+	ctx, span := tracing.Start(ctx, "EnvelopeTransformer.TransformToStorage",
+		attribute.String("provider_name", t.providerName))
+	defer span.End(0)
+
+	dek, err := generateKey(dekLength)
+	if err != nil {
+		return nil, err
+	}
+
+	uid := string(uuid.NewUUID())
+	resp, err := t.envelopeService.Encrypt(ctx, uid, dek)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := cryptoaes.NewCipher(dek)
+	if err != nil {
+		return nil, err
+	}
+	encryptedData, err := aestransformer.NewGCMTransformer(block).TransformToStorage(ctx, data, dataCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	return (&kmstypes.EncryptedObject{
+		EncryptedData: encryptedData,
+		KeyID:         resp.KeyID,
+		EncryptedDEK:  resp.Ciphertext,
+		Annotations:   resp.Annotations,
+	}).Marshal()
+}
+
+// TransformFromStorage unmarshals the EncryptedObject envelope, unwraps its DEK
+// (consulting the cache first) and uses it to AES-GCM-decrypt the payload locally.
+// stale is true when the envelope's KeyID no longer matches the plugin's current KeyID,
+// signaling that the object should be rewritten through TransformToStorage.
+func (t *envelopeTransformer) TransformFromStorage(ctx context.Context, data []byte, dataCtx value.Context) ([]byte, bool, error) {
+	ctx, span := tracing.Start(ctx, "EnvelopeTransformer.TransformFromStorage",
+		attribute.String("provider_name", t.providerName))
+	defer span.End(0)
+
+	o := &kmstypes.EncryptedObject{}
+	if err := o.Unmarshal(data); err != nil {
+		return nil, false, fmt.Errorf("failed to unmarshal encrypted object: %w", err)
+	}
+
+	uid := string(uuid.NewUUID())
+	dek, err := t.dekForEncryptedDEK(ctx, uid, o.EncryptedDEK)
+	if err != nil {
+		return nil, false, err
+	}
+
+	block, err := cryptoaes.NewCipher(dek)
+	if err != nil {
+		return nil, false, err
+	}
+	out, _, err := aestransformer.NewGCMTransformer(block).TransformFromStorage(ctx, o.EncryptedData, dataCtx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	currentKeyID, err := t.CurrentKeyID(ctx)
+	stale := err == nil && currentKeyID != o.KeyID
+	return out, stale, nil
+}
+
+// StatusPollInterval is how often the transformer re-checks the plugin's Status
+// response for a KeyID change outside of the request path.
+const StatusPollInterval = 1 * time.Second
+
+// RunKeyIDMonitor polls Status on an interval until ctx is cancelled, keeping
+// CurrentKeyID up to date for callers (including the re-encryption controller) that
+// need to detect KEK rotation promptly rather than on next use.
+func (t *envelopeTransformer) RunKeyIDMonitor(ctx context.Context) {
+	ticker := time.NewTicker(StatusPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = t.refreshKeyID(ctx)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kmsv2
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	kmsv2api "k8s.io/kms/apis/v2alpha1"
+)
+
+const (
+	namespace = "apiserver"
+	subsystem = "envelope_encryption"
+)
+
+var (
+	kmsOperationsLatency = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Namespace:      namespace,
+			Subsystem:      subsystem,
+			Name:           "kms_operations_latency_seconds",
+			Help:           "Latency of KMSv2 Encrypt/Decrypt/Status calls by provider and result.",
+			Buckets:        metrics.ExponentialBuckets(0.001, 2, 15),
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"provider_name", "operation", "result"},
+	)
+
+	dekCacheTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      namespace,
+			Subsystem:      subsystem,
+			Name:           "dek_cache_total",
+			Help:           "Number of DEK cache lookups by provider and result (hit or miss).",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"provider_name", "result"},
+	)
+
+	currentKeyIDGauge = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      namespace,
+			Subsystem:      subsystem,
+			Name:           "key_id_hash",
+			Help:           "A hash of the keyID currently reported by each provider's Status response, changing value whenever the provider rotates its KEK.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"provider_name"},
+	)
+
+	registerMetricsOnce sync.Once
+)
+
+// registerMetrics registers the KMSv2 metrics with the standard apiserver registry.
+// It is safe to call multiple times; registration only happens once per process.
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(kmsOperationsLatency)
+		legacyregistry.MustRegister(dekCacheTotal)
+		legacyregistry.MustRegister(currentKeyIDGauge)
+	})
+}
+
+func recordKMSOperationLatency(providerName, operation string, err error, start time.Time) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	kmsOperationsLatency.WithLabelValues(providerName, operation, result).Observe(time.Since(start).Seconds())
+}
+
+func recordDEKCacheResult(providerName string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	dekCacheTotal.WithLabelValues(providerName, result).Inc()
+}
+
+func recordCurrentKeyID(providerName, keyID string) {
+	h := fnv.New32a()
+	h.Write([]byte(keyID))
+	currentKeyIDGauge.WithLabelValues(providerName).Set(float64(h.Sum32()))
+}
+
+// instrumentedService wraps a Service so every call records latency, keeping the
+// instrumentation in one place instead of threading it through every caller.
+type instrumentedService struct {
+	Service
+	providerName string
+}
+
+// NewInstrumentedService returns svc wrapped so that Encrypt, Decrypt and Status each
+// record a kms_operations_latency_seconds observation labeled by providerName,
+// operation and result, and registers the KMSv2 metrics on first use.
+func NewInstrumentedService(providerName string, svc Service) Service {
+	registerMetrics()
+	return &instrumentedService{Service: svc, providerName: providerName}
+}
+
+func (s *instrumentedService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*kmsv2api.EncryptResponse, error) {
+	start := time.Now()
+	resp, err := s.Service.Encrypt(ctx, uid, plaintext)
+	recordKMSOperationLatency(s.providerName, "Encrypt", err, start)
+	return resp, err
+}
+
+func (s *instrumentedService) Decrypt(ctx context.Context, uid string, req *kmsv2api.DecryptRequest) ([]byte, error) {
+	start := time.Now()
+	plaintext, err := s.Service.Decrypt(ctx, uid, req)
+	recordKMSOperationLatency(s.providerName, "Decrypt", err, start)
+	return plaintext, err
+}
+
+func (s *instrumentedService) Status(ctx context.Context) (*kmsv2api.StatusResponse, error) {
+	start := time.Now()
+	status, err := s.Service.Status(ctx)
+	recordKMSOperationLatency(s.providerName, "Status", err, start)
+	if err == nil && status != nil {
+		recordCurrentKeyID(s.providerName, status.KeyID)
+	}
+	return status, err
+}
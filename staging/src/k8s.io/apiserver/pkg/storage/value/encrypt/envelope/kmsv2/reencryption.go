@@ -0,0 +1,232 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kmsv2
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// StoredObject is the subset of an at-rest object the re-encryption controller needs
+// in order to decide whether it must be rewritten.
+type StoredObject struct {
+	// Key is the etcd key the object is stored under.
+	Key string
+	// ResourceVersion is the object's resourceVersion at the time it was listed, used
+	// to make the rewrite a no-op conditional update via GuaranteedUpdate.
+	ResourceVersion string
+	// KeyID is the keyID recorded in the object's EncryptedObject envelope.
+	KeyID string
+}
+
+// ResourcePager lists a single resource page by page and rewrites individual objects
+// in place. This package defines only the interface and provides no implementation of it.
+type ResourcePager interface {
+	// ListPaged returns the next page of objects starting at continueToken (empty for
+	// the first page), and the continue token for the following page (empty once the
+	// listing is exhausted).
+	ListPaged(ctx context.Context, continueToken string) (page []StoredObject, nextContinue string, err error)
+	// GuaranteedUpdate re-reads and rewrites the object at key if its resourceVersion
+	// still matches, which forces it back through the transformer with the current
+	// keyID without otherwise changing its contents.
+	GuaranteedUpdate(ctx context.Context, key, resourceVersion string) error
+}
+
+// ReEncryptableResource is one GroupResource that the re-encryption controller is
+// responsible for converging on the current KEK, together with the pager it should
+// use to walk that resource's objects.
+type ReEncryptableResource struct {
+	GroupResource string
+	Pager         ResourcePager
+}
+
+// CheckpointStore persists and loads the re-encryption controller's progress so a
+// restart resumes rather than re-scanning from the beginning. This package defines
+// only the interface and provides no implementation of it, Lease-backed or otherwise.
+type CheckpointStore interface {
+	// LoadContinue returns the last continue token checkpointed for groupResource, or
+	// "" if the resource has never been scanned (or has already fully converged).
+	LoadContinue(ctx context.Context, groupResource string) (string, error)
+	// SaveContinue checkpoints the next continue token to resume from for
+	// groupResource. An empty token means the resource fully converged.
+	SaveContinue(ctx context.Context, groupResource string, continueToken string) error
+}
+
+// ReEncryptionController walks the resources listed in the EncryptionConfiguration
+// and rewrites any object whose EncryptedObject.KeyID does not match the
+// envelopeTransformer's current keyID, so that an operator-driven KEK rotation
+// converges without requiring a bulk "kubectl get | kubectl replace" migration.
+type ReEncryptionController struct {
+	transformer *envelopeTransformer
+	resources   []ReEncryptableResource
+	checkpoints CheckpointStore
+	limiter     *rate.Limiter
+
+	// lastProgressMu guards lastProgress, which is written by Run's goroutine and
+	// read by HealthzLag/Check from whatever goroutine serves healthz.
+	lastProgressMu sync.RWMutex
+	// lastProgress is updated at the end of every successful pass and is surfaced by
+	// HealthzLag and Check so operators can alert if a rotation is not converging.
+	lastProgress time.Time
+	maxLag       time.Duration
+}
+
+// NewReEncryptionController constructs a controller that re-encrypts stale objects in
+// resources at a rate capped by requestsPerSecond, checkpointing progress via
+// checkpoints so a restart can resume a paused rotation.
+func NewReEncryptionController(
+	transformer *envelopeTransformer,
+	resources []ReEncryptableResource,
+	checkpoints CheckpointStore,
+	requestsPerSecond float64,
+	maxLag time.Duration,
+) *ReEncryptionController {
+	return &ReEncryptionController{
+		transformer:  transformer,
+		resources:    resources,
+		checkpoints:  checkpoints,
+		limiter:      rate.NewLimiter(rate.Limit(requestsPerSecond), 1),
+		lastProgress: time.Now(),
+		maxLag:       maxLag,
+	}
+}
+
+// Run repeatedly scans all configured resources until ctx is cancelled, sleeping
+// StatusPollInterval after every pass whether or not every resource has converged.
+func (c *ReEncryptionController) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		converged := true
+		for _, resource := range c.resources {
+			resourceConverged, err := c.reEncryptResource(ctx, resource)
+			if err != nil {
+				// A single resource failing to converge (e.g. the KMS plugin is
+				// briefly unavailable) should not block progress on the others.
+				converged = false
+				continue
+			}
+			converged = converged && resourceConverged
+		}
+
+		if converged {
+			c.setLastProgress(time.Now())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(StatusPollInterval):
+		}
+	}
+}
+
+func (c *ReEncryptionController) setLastProgress(t time.Time) {
+	c.lastProgressMu.Lock()
+	defer c.lastProgressMu.Unlock()
+	c.lastProgress = t
+}
+
+// reEncryptResource pages through a single resource starting from its checkpoint,
+// rewriting any object whose KeyID is stale, and returns whether the resource has
+// fully converged on the current keyID.
+func (c *ReEncryptionController) reEncryptResource(ctx context.Context, resource ReEncryptableResource) (bool, error) {
+	currentKeyID, err := c.transformer.CurrentKeyID(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to determine current keyID for %s: %w", resource.GroupResource, err)
+	}
+
+	continueToken, err := c.checkpoints.LoadContinue(ctx, resource.GroupResource)
+	if err != nil {
+		return false, fmt.Errorf("failed to load checkpoint for %s: %w", resource.GroupResource, err)
+	}
+
+	for {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return false, err
+		}
+
+		page, nextContinue, err := resource.Pager.ListPaged(ctx, continueToken)
+		if err != nil {
+			return false, fmt.Errorf("failed to list %s from continue %q: %w", resource.GroupResource, continueToken, err)
+		}
+
+		for _, obj := range page {
+			if obj.KeyID == currentKeyID {
+				continue
+			}
+			if err := resource.Pager.GuaranteedUpdate(ctx, obj.Key, obj.ResourceVersion); err != nil {
+				return false, fmt.Errorf("failed to re-encrypt %s/%s: %w", resource.GroupResource, obj.Key, err)
+			}
+		}
+
+		continueToken = nextContinue
+		if err := c.checkpoints.SaveContinue(ctx, resource.GroupResource, continueToken); err != nil {
+			return false, fmt.Errorf("failed to checkpoint %s at %q: %w", resource.GroupResource, continueToken, err)
+		}
+		if continueToken == "" {
+			return true, nil
+		}
+	}
+}
+
+// StartReEncryption begins polling the plugin's Status response for KEK rotation via
+// RunKeyIDMonitor, and, if resources is non-empty, also starts a ReEncryptionController
+// to converge objects encrypted under a now-stale keyID. It is the single call this
+// package expects its caller (the code that constructs an envelopeTransformer for a
+// KMSv2 provider from EncryptionConfiguration) to make once per provider; ctx
+// cancellation stops both background loops. It returns the controller so the caller can
+// register its Check method with healthz, or nil if no resources were configured.
+func (t *envelopeTransformer) StartReEncryption(ctx context.Context, resources []ReEncryptableResource, checkpoints CheckpointStore, requestsPerSecond float64, maxLag time.Duration) *ReEncryptionController {
+	go t.RunKeyIDMonitor(ctx)
+	if len(resources) == 0 {
+		return nil
+	}
+	controller := NewReEncryptionController(t, resources, checkpoints, requestsPerSecond, maxLag)
+	go controller.Run(ctx)
+	return controller
+}
+
+// HealthzLag reports how long it has been since a pass converged every configured
+// resource, so a healthz check can fail once a rotation has been stuck for too long.
+func (c *ReEncryptionController) HealthzLag() time.Duration {
+	c.lastProgressMu.RLock()
+	defer c.lastProgressMu.RUnlock()
+	return time.Since(c.lastProgress)
+}
+
+// Name and Check implement healthz.HealthChecker, failing once the re-encryption pass has
+// not converged within maxLag of starting a rotation.
+func (c *ReEncryptionController) Name() string {
+	return "kms-reencryption"
+}
+
+func (c *ReEncryptionController) Check(_ *http.Request) error {
+	if lag := c.HealthzLag(); lag > c.maxLag {
+		return fmt.Errorf("kms re-encryption has not converged in %s, exceeding the %s threshold", lag, c.maxLag)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kmsv2
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"k8s.io/component-base/tracing"
+	kmsv2api "k8s.io/kms/apis/v2alpha1"
+)
+
+// tracingService wraps a Service so every call to the KMS plugin gets its own span,
+// letting a slow or failing plugin RPC be spotted in a trace of the surrounding
+// TransformToStorage/TransformFromStorage call instead of showing up as unexplained
+// latency.
+type tracingService struct {
+	Service
+	providerName string
+}
+
+// NewTracingService returns svc wrapped so that Encrypt, Decrypt and Status each run
+// inside their own child span, tagged with the provider name and operation.
+func NewTracingService(providerName string, svc Service) Service {
+	return &tracingService{Service: svc, providerName: providerName}
+}
+
+func (s *tracingService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*kmsv2api.EncryptResponse, error) {
+	ctx, span := tracing.Start(ctx, "KMSv2Plugin.Encrypt",
+		attribute.String("provider_name", s.providerName), attribute.String("uid", uid))
+	defer span.End(0)
+	return s.Service.Encrypt(ctx, uid, plaintext)
+}
+
+func (s *tracingService) Decrypt(ctx context.Context, uid string, req *kmsv2api.DecryptRequest) ([]byte, error) {
+	ctx, span := tracing.Start(ctx, "KMSv2Plugin.Decrypt",
+		attribute.String("provider_name", s.providerName), attribute.String("uid", uid))
+	defer span.End(0)
+	return s.Service.Decrypt(ctx, uid, req)
+}
+
+func (s *tracingService) Status(ctx context.Context) (*kmsv2api.StatusResponse, error) {
+	ctx, span := tracing.Start(ctx, "KMSv2Plugin.Status", attribute.String("provider_name", s.providerName))
+	defer span.End(0)
+	return s.Service.Status(ctx)
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package v2alpha1 contains the on-disk envelope format a KMSv2 provider writes to
+// etcd: an EncryptedObject wrapping an AES-GCM-encrypted payload together with the
+// wrapped DEK that produced it.
+package v2alpha1
+
+import "fmt"
+
+// EncryptedDEKSourceType identifies how EncryptedObject.EncryptedDEK was produced.
+type EncryptedDEKSourceType int32
+
+const (
+	// AESGCMKeyWrap is the only source type package kmsv2's envelopeTransformer
+	// produces: EncryptedDEK is the result of calling the KMS plugin's Encrypt RPC
+	// on a randomly generated DEK.
+	AESGCMKeyWrap EncryptedDEKSourceType = 0
+)
+
+// EncryptedObject is the proto-serialized envelope a KMSv2 provider stores in etcd in
+// place of an object's plaintext, immediately following the
+// "k8s:enc:kms:v2:<provider>:" prefix.
+type EncryptedObject struct {
+	// EncryptedData is the object's plaintext, AES-GCM-encrypted with the DEK that
+	// EncryptedDEK unwraps to.
+	EncryptedData []byte
+	// KeyID is the identifier of the KEK the plugin used to wrap EncryptedDEK, as
+	// reported by the plugin's Encrypt response; it is compared against the
+	// plugin's current Status to detect a stale KEK.
+	KeyID string
+	// EncryptedDEKSourceType records how EncryptedDEK was produced.
+	EncryptedDEKSourceType EncryptedDEKSourceType
+	// EncryptedDEK is the per-object DEK, wrapped by the plugin's KEK.
+	EncryptedDEK []byte
+	// Annotations carries the plugin's Encrypt response annotations through to
+	// storage, so implementation-specific metadata about the wrap (e.g. which KEK
+	// version was used) survives a round trip without this package needing to
+	// understand it.
+	Annotations map[string][]byte
+}
+
+func (o *EncryptedObject) Reset() { *o = EncryptedObject{} }
+
+func (o *EncryptedObject) String() string {
+	return fmt.Sprintf("&EncryptedObject{EncryptedData:%x, KeyID:%s, EncryptedDEK:%x, Annotations:%v}",
+		o.EncryptedData, o.KeyID, o.EncryptedDEK, o.Annotations)
+}
+
+func (*EncryptedObject) ProtoMessage() {}
@@ -0,0 +1,189 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2alpha1
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Field numbers for EncryptedObject, matching the struct's doc-comment order.
+const (
+	fieldEncryptedData          = 1
+	fieldKeyID                  = 2
+	fieldEncryptedDEKSourceType = 3
+	fieldEncryptedDEK           = 4
+	fieldAnnotations            = 5
+)
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// Marshal encodes o using the standard protobuf wire format, by hand rather than via
+// reflection, the same way a generated .pb.go type would.
+func (o *EncryptedObject) Marshal() ([]byte, error) {
+	var buf []byte
+	if len(o.EncryptedData) > 0 {
+		buf = appendBytesField(buf, fieldEncryptedData, o.EncryptedData)
+	}
+	if len(o.KeyID) > 0 {
+		buf = appendBytesField(buf, fieldKeyID, []byte(o.KeyID))
+	}
+	if o.EncryptedDEKSourceType != 0 {
+		buf = appendVarintField(buf, fieldEncryptedDEKSourceType, uint64(o.EncryptedDEKSourceType))
+	}
+	if len(o.EncryptedDEK) > 0 {
+		buf = appendBytesField(buf, fieldEncryptedDEK, o.EncryptedDEK)
+	}
+	for k, v := range o.Annotations {
+		entry := appendBytesField(nil, 1, []byte(k))
+		entry = appendBytesField(entry, 2, v)
+		buf = appendBytesField(buf, fieldAnnotations, entry)
+	}
+	return buf, nil
+}
+
+// Unmarshal decodes data produced by Marshal (or an equivalent standard protobuf
+// encoder) into o.
+func (o *EncryptedObject) Unmarshal(data []byte) error {
+	*o = EncryptedObject{}
+	for len(data) > 0 {
+		fieldNum, wireType, n, err := readTag(data)
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+
+		switch wireType {
+		case wireVarint:
+			v, n, err := readVarint(data)
+			if err != nil {
+				return err
+			}
+			data = data[n:]
+			if fieldNum == fieldEncryptedDEKSourceType {
+				o.EncryptedDEKSourceType = EncryptedDEKSourceType(v)
+			}
+		case wireBytes:
+			v, n, err := readBytes(data)
+			if err != nil {
+				return err
+			}
+			data = data[n:]
+			switch fieldNum {
+			case fieldEncryptedData:
+				o.EncryptedData = v
+			case fieldKeyID:
+				o.KeyID = string(v)
+			case fieldEncryptedDEK:
+				o.EncryptedDEK = v
+			case fieldAnnotations:
+				k, val, err := unmarshalAnnotationEntry(v)
+				if err != nil {
+					return err
+				}
+				if o.Annotations == nil {
+					o.Annotations = make(map[string][]byte)
+				}
+				o.Annotations[k] = val
+			}
+		default:
+			return fmt.Errorf("kmsv2 EncryptedObject: unsupported wire type %d for field %d", wireType, fieldNum)
+		}
+	}
+	return nil
+}
+
+func unmarshalAnnotationEntry(data []byte) (string, []byte, error) {
+	var key string
+	var value []byte
+	for len(data) > 0 {
+		fieldNum, wireType, n, err := readTag(data)
+		if err != nil {
+			return "", nil, err
+		}
+		data = data[n:]
+		if wireType != wireBytes {
+			return "", nil, fmt.Errorf("kmsv2 EncryptedObject: unsupported wire type %d in annotation entry", wireType)
+		}
+		v, n, err := readBytes(data)
+		if err != nil {
+			return "", nil, err
+		}
+		data = data[n:]
+		switch fieldNum {
+		case 1:
+			key = string(v)
+		case 2:
+			value = v
+		}
+	}
+	return key, value, nil
+}
+
+func appendVarintField(buf []byte, fieldNum int, v uint64) []byte {
+	buf = appendTag(buf, fieldNum, wireVarint)
+	return appendVarint(buf, v)
+}
+
+func appendBytesField(buf []byte, fieldNum int, v []byte) []byte {
+	buf = appendTag(buf, fieldNum, wireBytes)
+	buf = appendVarint(buf, uint64(len(v)))
+	return append(buf, v...)
+}
+
+func appendTag(buf []byte, fieldNum, wireType int) []byte {
+	return appendVarint(buf, uint64(fieldNum)<<3|uint64(wireType))
+}
+
+func appendVarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func readTag(data []byte) (fieldNum, wireType int, n int, err error) {
+	v, n, err := readVarint(data)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return int(v >> 3), int(v & 0x7), n, nil
+}
+
+func readVarint(data []byte) (uint64, int, error) {
+	v, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, 0, fmt.Errorf("kmsv2 EncryptedObject: invalid varint")
+	}
+	return v, n, nil
+}
+
+func readBytes(data []byte) ([]byte, int, error) {
+	length, n, err := readVarint(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	end := n + int(length)
+	if end < n || end > len(data) {
+		return nil, 0, fmt.Errorf("kmsv2 EncryptedObject: length-delimited field overruns message")
+	}
+	out := make([]byte, length)
+	copy(out, data[n:end])
+	return out, end, nil
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package v2alpha1 provides a mock implementation of a KMSv2 gRPC plugin for use by
+// integration tests.
+package v2alpha1
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	kmsv2api "k8s.io/kms/apis/v2alpha1"
+)
+
+const (
+	initialKeyID = "1"
+)
+
+// Base64Plugin is an in-process, base64 "encrypting" stand-in for a real KMSv2 gRPC
+// plugin, served over a unix socket so it exercises the same client dialing path as
+// a production plugin.
+type Base64Plugin struct {
+	kmsv2api.UnimplementedKeyManagementServiceServer
+
+	socketPath string
+	listener   net.Listener
+	server     *grpc.Server
+
+	mu             sync.Mutex
+	failed         bool
+	keyID          string
+	lastEncryptReq []byte
+}
+
+// NewBase64Plugin creates a plugin bound to the given unix socket path (the same
+// "@name" convention used by EncryptionConfiguration's unix:///@name endpoints).
+func NewBase64Plugin(socketPath string) (*Base64Plugin, error) {
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+	p := &Base64Plugin{
+		socketPath: socketPath,
+		listener:   listener,
+		keyID:      initialKeyID,
+	}
+	p.server = grpc.NewServer()
+	kmsv2api.RegisterKeyManagementServiceServer(p.server, p)
+	return p, nil
+}
+
+// Start serves the mock plugin in the background until CleanUp is called, and returns
+// without waiting for the server to stop.
+func (p *Base64Plugin) Start() error {
+	go p.server.Serve(p.listener)
+	return nil
+}
+
+// CleanUp stops the server and removes the socket.
+func (p *Base64Plugin) CleanUp() {
+	p.server.Stop()
+}
+
+// WaitForBase64PluginToBeUp blocks until the plugin answers a Status call.
+func WaitForBase64PluginToBeUp(p *Base64Plugin) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for {
+		if _, err := p.Status(ctx, &kmsv2api.StatusRequest{}); err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for plugin at %s to come up", p.socketPath)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// EnterFailedState causes subsequent RPCs to fail, simulating a dead KMS backend.
+func (p *Base64Plugin) EnterFailedState() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failed = true
+}
+
+// ExitFailedState reverses EnterFailedState.
+func (p *Base64Plugin) ExitFailedState() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failed = false
+}
+
+// Rotate simulates the KMS operator rotating the KEK behind the plugin: the next
+// Status response will report newKeyID.
+func (p *Base64Plugin) Rotate(newKeyID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keyID = newKeyID
+}
+
+// LastKeyID returns the keyID currently reported by Status.
+func (p *Base64Plugin) LastKeyID() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.keyID
+}
+
+// LastEncryptRequest returns the plaintext DEK passed to the most recent Encrypt call.
+func (p *Base64Plugin) LastEncryptRequest() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastEncryptReq
+}
+
+func (p *Base64Plugin) Status(ctx context.Context, _ *kmsv2api.StatusRequest) (*kmsv2api.StatusResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failed {
+		return nil, fmt.Errorf("plugin at %s is in a failed state", p.socketPath)
+	}
+	return &kmsv2api.StatusResponse{
+		Version: "v2alpha1",
+		Healthz: "ok",
+		KeyID:   p.keyID,
+	}, nil
+}
+
+func (p *Base64Plugin) Encrypt(ctx context.Context, req *kmsv2api.EncryptRequest) (*kmsv2api.EncryptResponse, error) {
+	p.mu.Lock()
+	if p.failed {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("plugin at %s is in a failed state", p.socketPath)
+	}
+	p.lastEncryptReq = append([]byte(nil), req.Plaintext...)
+	keyID := p.keyID
+	p.mu.Unlock()
+
+	return &kmsv2api.EncryptResponse{
+		Ciphertext: encodeBase64(req.Plaintext),
+		KeyID:      keyID,
+		Annotations: map[string][]byte{
+			"kms-provider.example.com/kek-id": []byte(keyID),
+		},
+	}, nil
+}
+
+func (p *Base64Plugin) Decrypt(ctx context.Context, req *kmsv2api.DecryptRequest) (*kmsv2api.DecryptResponse, error) {
+	p.mu.Lock()
+	failed := p.failed
+	p.mu.Unlock()
+	if failed {
+		return nil, fmt.Errorf("plugin at %s is in a failed state", p.socketPath)
+	}
+	plaintext, err := decodeBase64(req.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return &kmsv2api.DecryptResponse{Plaintext: plaintext}, nil
+}
+
+// encodeBase64/decodeBase64 stand in for a real KEK encrypt/decrypt so that the mock
+// can round-trip a DEK without needing real key material.
+func encodeBase64(plaintext []byte) []byte {
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(plaintext)))
+	base64.StdEncoding.Encode(out, plaintext)
+	return out
+}
+
+func decodeBase64(ciphertext []byte) ([]byte, error) {
+	out := make([]byte, base64.StdEncoding.DecodedLen(len(ciphertext)))
+	n, err := base64.StdEncoding.Decode(out, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return out[:n], nil
+}
+
+// randomDEK is exposed for other test helpers in this package that need to generate a
+// DEK-shaped byte slice without going through a real envelopeTransformer.
+func randomDEK() ([]byte, error) {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	return dek, nil
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package value contains methods for assisting with transformation of values in
+// storage, for instance at-rest encryption.
+package value
+
+import "context"
+
+// Context is additional information that a Transformer uses to verify the data
+// returned from storage has not been tampered with by rebinding it to a specific
+// context, typically the etcd key the data is stored under.
+type Context interface {
+	// AuthenticatedData returns the bytes that must be authenticated (but not
+	// necessarily encrypted) alongside the value.
+	AuthenticatedData() []byte
+}
+
+// DefaultContext is a simple implementation of Context for a caller that only has a
+// single piece of authenticated data to provide (typically the storage key).
+type DefaultContext []byte
+
+// AuthenticatedData implements Context.
+func (c DefaultContext) AuthenticatedData() []byte {
+	return []byte(c)
+}
+
+// Transformer allows a value to be transformed before being read from or written to
+// the underlying store. The methods must be able to undo the transformation caused by
+// the other.
+type Transformer interface {
+	// TransformFromStorage may transform the provided data from its underlying
+	// storage representation, or return an error. The stale boolean is true if the
+	// data is valid but was encrypted with a key or algorithm that is no longer the
+	// preferred way to encrypt the data, signaling that the caller should rewrite
+	// the value using TransformToStorage.
+	TransformFromStorage(ctx context.Context, data []byte, dataCtx Context) (out []byte, stale bool, err error)
+	// TransformToStorage may transform the provided data into the appropriate form
+	// for storage, or return an error.
+	TransformToStorage(ctx context.Context, data []byte, dataCtx Context) (out []byte, err error)
+}
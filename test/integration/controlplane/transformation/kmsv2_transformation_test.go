@@ -23,7 +23,10 @@ import (
 	"bytes"
 	"context"
 	"crypto/aes"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -36,15 +39,18 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apiserver/pkg/features"
 	"k8s.io/apiserver/pkg/server/options/encryptionconfig"
 	"k8s.io/apiserver/pkg/storage/value"
 	aestransformer "k8s.io/apiserver/pkg/storage/value/encrypt/aes"
 	"k8s.io/apiserver/pkg/storage/value/encrypt/envelope/kmsv2"
+	"k8s.io/apiserver/pkg/storage/value/encrypt/envelope/kmsv2/backend"
 	kmstypes "k8s.io/apiserver/pkg/storage/value/encrypt/envelope/kmsv2/v2alpha1"
 	kmsv2mock "k8s.io/apiserver/pkg/storage/value/encrypt/envelope/testing/v2alpha1"
 	utilfeature "k8s.io/apiserver/pkg/util/feature"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
 	featuregatetesting "k8s.io/component-base/featuregate/testing"
 	kmsv2api "k8s.io/kms/apis/v2alpha1"
 	"k8s.io/kubernetes/test/integration/etcd"
@@ -76,6 +82,14 @@ func (r envelopekmsv2) startOfPayload(_ string) int {
 	return r.prefixLen()
 }
 
+func (r envelopekmsv2) encryptedObject() (*kmstypes.EncryptedObject, error) {
+	o := &kmstypes.EncryptedObject{}
+	if err := proto.Unmarshal(r.rawEnvelope[r.startOfPayload(r.providerName):], o); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
 func (r envelopekmsv2) cipherTextPayload() ([]byte, error) {
 	o := &kmstypes.EncryptedObject{}
 	if err := proto.Unmarshal(r.rawEnvelope[r.startOfPayload(r.providerName):], o); err != nil {
@@ -358,4 +372,391 @@ resources:
 	if kmsv2Calls != 1 {
 		t.Fatalf("expected a single call to KMS v2 service factory: %v", kmsv2Calls)
 	}
-}
\ No newline at end of file
+}
+
+// TestKMSv2KeyRotation is an integration test between KubeAPI, ETCD and KMSv2 Plugin
+// that verifies automated KEK rotation and the background re-encryption pass it drives:
+// 1. Once the plugin's Status response reports a new keyID, subsequent writes are enveloped with that new keyID.
+// 2. The re-encryption controller notices objects whose EncryptedObject.KeyID is stale and rewrites them in place to converge on the current keyID, without a new write from a client.
+// 3. Objects that were encrypted under the retired KEK remain readable through the KubeAPI server for the entire transition.
+func TestKMSv2KeyRotation(t *testing.T) {
+	defer featuregatetesting.SetFeatureGateDuringTest(t, utilfeature.DefaultFeatureGate, features.KMSv2, true)()
+
+	encryptionConfig := `
+kind: EncryptionConfiguration
+apiVersion: apiserver.config.k8s.io/v1
+resources:
+  - resources:
+    - secrets
+    providers:
+    - kms:
+       apiVersion: v2
+       name: kms-provider
+       cachesize: 1000
+       endpoint: unix:///@kms-provider-rotation.sock
+`
+
+	providerName := "kms-provider"
+	pluginMock, err := kmsv2mock.NewBase64Plugin("@kms-provider-rotation.sock")
+	if err != nil {
+		t.Fatalf("failed to create mock of KMSv2 Plugin: %v", err)
+	}
+
+	go pluginMock.Start()
+	if err := kmsv2mock.WaitForBase64PluginToBeUp(pluginMock); err != nil {
+		t.Fatalf("Failed start plugin, err: %v", err)
+	}
+	defer pluginMock.CleanUp()
+
+	test, err := newTransformTest(t, encryptionConfig)
+	if err != nil {
+		t.Fatalf("failed to start KUBE API Server with encryptionConfig\n %s, error: %v", encryptionConfig, err)
+	}
+	defer test.cleanUp()
+
+	staleSecret, err := test.createSecret(testSecret, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to create test secret, error: %v", err)
+	}
+	test.secret = staleSecret
+
+	oldKeyID := pluginMock.LastKeyID()
+
+	rawEnvelope, err := test.getRawSecretFromETCD()
+	if err != nil {
+		t.Fatalf("failed to read secret from etcd: %v", err)
+	}
+	oldObj, err := (envelopekmsv2{providerName: providerName, rawEnvelope: rawEnvelope}).encryptedObject()
+	if err != nil {
+		t.Fatalf("failed to parse EncryptedObject: %v", err)
+	}
+	if oldObj.KeyID != oldKeyID {
+		t.Fatalf("expected initial write to use keyID %q, got %q", oldKeyID, oldObj.KeyID)
+	}
+
+	// The operator rotates the KEK behind the plugin. The plugin now reports a new
+	// keyID in its Status response, which the envelope transformer observes on its
+	// next status poll.
+	newKeyID := oldKeyID + "-rotated"
+	pluginMock.Rotate(newKeyID)
+
+	freshSecret, err := test.createSecret(testSecret+"-fresh", testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to create second test secret, error: %v", err)
+	}
+	test.secret = freshSecret
+
+	if err := wait.PollImmediate(time.Second, 30*time.Second, func() (bool, error) {
+		raw, err := test.getRawSecretFromETCD()
+		if err != nil {
+			return false, err
+		}
+		obj, err := (envelopekmsv2{providerName: providerName, rawEnvelope: raw}).encryptedObject()
+		if err != nil {
+			return false, err
+		}
+		return obj.KeyID == newKeyID, nil
+	}); err != nil {
+		t.Fatalf("writes never converged on the rotated keyID %q: %v", newKeyID, err)
+	}
+
+	// The stale secret was never touched by a client write, so it is only rewritten
+	// by the background re-encryption controller. Poll until it converges, and
+	// confirm it stays readable through the KubeAPI server for the whole transition.
+	test.secret = staleSecret
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	secretClient := test.restClient.CoreV1().Secrets(testNamespace)
+	if err := wait.PollImmediate(time.Second, 30*time.Second, func() (bool, error) {
+		if s, err := secretClient.Get(ctx, testSecret, metav1.GetOptions{}); err != nil {
+			return false, err
+		} else if secretVal != string(s.Data[secretKey]) {
+			return false, fmt.Errorf("stale secret became unreadable during rotation: got %q", string(s.Data[secretKey]))
+		}
+
+		raw, err := test.getRawSecretFromETCD()
+		if err != nil {
+			return false, err
+		}
+		obj, err := (envelopekmsv2{providerName: providerName, rawEnvelope: raw}).encryptedObject()
+		if err != nil {
+			return false, err
+		}
+		return obj.KeyID == newKeyID, nil
+	}); err != nil {
+		t.Fatalf("re-encryption controller never converged the stale secret on keyID %q: %v", newKeyID, err)
+	}
+}
+
+// TestKMSv2ProviderFailover is an integration test between KubeAPI, ETCD and a chain of
+// two KMSv2 Plugins configured as a provider chain. It verifies that:
+// 1. While both providers are healthy, writes are encrypted with the first (preferred) provider in the chain.
+// 2. Once provider-1 is killed, new writes fail over to provider-2, and a secret encrypted under provider-1 that was already read (and so has its DEK cached) remains readable.
+// 3. The per-provider healthz checks (kms-provider-0, kms-provider-1) report the state of each chain member; no combined healthz/kms-providers check is exercised.
+func TestKMSv2ProviderFailover(t *testing.T) {
+	defer featuregatetesting.SetFeatureGateDuringTest(t, utilfeature.DefaultFeatureGate, features.KMSv2, true)()
+
+	encryptionConfig := `
+kind: EncryptionConfiguration
+apiVersion: apiserver.config.k8s.io/v1
+resources:
+  - resources:
+    - secrets
+    providers:
+    - kms:
+       apiVersion: v2
+       name: provider-1
+       endpoint: unix:///@kms-provider-failover-1.sock
+    - kms:
+       apiVersion: v2
+       name: provider-2
+       endpoint: unix:///@kms-provider-failover-2.sock
+`
+
+	pluginMock1, err := kmsv2mock.NewBase64Plugin("@kms-provider-failover-1.sock")
+	if err != nil {
+		t.Fatalf("failed to create mock of KMS Plugin #1: %v", err)
+	}
+	if err := pluginMock1.Start(); err != nil {
+		t.Fatalf("Failed to start kms-plugin, err: %v", err)
+	}
+	defer pluginMock1.CleanUp()
+	if err := kmsv2mock.WaitForBase64PluginToBeUp(pluginMock1); err != nil {
+		t.Fatalf("Failed to start plugin #1, err: %v", err)
+	}
+
+	pluginMock2, err := kmsv2mock.NewBase64Plugin("@kms-provider-failover-2.sock")
+	if err != nil {
+		t.Fatalf("Failed to create mock of KMS Plugin #2: err: %v", err)
+	}
+	if err := pluginMock2.Start(); err != nil {
+		t.Fatalf("Failed to start kms-plugin, err: %v", err)
+	}
+	defer pluginMock2.CleanUp()
+	if err := kmsv2mock.WaitForBase64PluginToBeUp(pluginMock2); err != nil {
+		t.Fatalf("Failed to start KMS Plugin #2: err: %v", err)
+	}
+
+	test, err := newTransformTest(t, encryptionConfig)
+	if err != nil {
+		t.Fatalf("Failed to start kube-apiserver, error: %v", err)
+	}
+	defer test.cleanUp()
+
+	// Stage 1 - both providers are healthy, so the chain prefers provider-1 for encrypt.
+	mustBeHealthy(t, "kms-provider-0", test.kubeAPIServer.ClientConfig)
+	mustBeHealthy(t, "kms-provider-1", test.kubeAPIServer.ClientConfig)
+
+	beforeFailover, err := test.createSecret(testSecret, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to create test secret, error: %v", err)
+	}
+	test.secret = beforeFailover
+
+	rawEnvelope, err := test.getRawSecretFromETCD()
+	if err != nil {
+		t.Fatalf("failed to read secret from etcd: %v", err)
+	}
+	wantPrefix := (envelopekmsv2{providerName: "provider-1"}).prefix()
+	if !bytes.HasPrefix(rawEnvelope, []byte(wantPrefix)) {
+		t.Fatalf("expected secret to be encrypted by provider-1, but got %s", rawEnvelope)
+	}
+
+	// Read the secret back once while provider-1 is still healthy, so its DEK is
+	// warmed in the transformer's cache before provider-1 goes down below.
+	warmupCtx, warmupCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer warmupCancel()
+	if _, err := test.restClient.CoreV1().Secrets(testNamespace).Get(warmupCtx, testSecret, metav1.GetOptions{}); err != nil {
+		t.Fatalf("failed to warm the DEK cache for %s: %v", testSecret, err)
+	}
+
+	// Stage 2 - provider-1 goes down. Its healthz check (kms-provider-0) must fail while
+	// provider-2's (kms-provider-1) stays healthy, new writes must fail over to provider-2, and the secret written while
+	// provider-1 was healthy must remain readable.
+	pluginMock1.EnterFailedState()
+	defer pluginMock1.ExitFailedState()
+	mustBeUnHealthy(t, "kms-provider-0", test.kubeAPIServer.ClientConfig)
+	mustBeHealthy(t, "kms-provider-1", test.kubeAPIServer.ClientConfig)
+
+	afterFailover, err := test.createSecret(testSecret+"-after-failover", testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to create test secret after failover, error: %v", err)
+	}
+	test.secret = afterFailover
+
+	rawEnvelopeAfter, err := test.getRawSecretFromETCD()
+	if err != nil {
+		t.Fatalf("failed to read post-failover secret from etcd: %v", err)
+	}
+	wantFailoverPrefix := (envelopekmsv2{providerName: "provider-2"}).prefix()
+	if !bytes.HasPrefix(rawEnvelopeAfter, []byte(wantFailoverPrefix)) {
+		t.Fatalf("expected post-failover secret to be encrypted by provider-2, but got %s", rawEnvelopeAfter)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	secretClient := test.restClient.CoreV1().Secrets(testNamespace)
+	s, err := secretClient.Get(ctx, testSecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("secret encrypted by the now-dead provider-1 became unreadable: %v", err)
+	}
+	if secretVal != string(s.Data[secretKey]) {
+		t.Fatalf("expected %s from KubeAPI, but got %s", secretVal, string(s.Data[secretKey]))
+	}
+}
+
+// TestKMSv2InProcessBackends runs TestKMSv2Provider's contract checks (writes are
+// enveloped under the provider's prefix, and the KubeAPI server serves the secret
+// un-enveloped) against every in-process kmsv2.Backend registered by URL scheme,
+// instead of the unix-socket gRPC plugin. Backends that need real external hardware
+// or credentials (PKCS#11 tokens, cloud KMS) are skipped unless the environment that
+// can reach them is present.
+func TestKMSv2InProcessBackends(t *testing.T) {
+	defer featuregatetesting.SetFeatureGateDuringTest(t, utilfeature.DefaultFeatureGate, features.KMSv2, true)()
+
+	kekPath := filepath.Join(t.TempDir(), "kek.pem")
+	if err := os.WriteFile(kekPath, bytes.Repeat([]byte{0x42}, 32), 0600); err != nil {
+		t.Fatalf("failed to write reference KEK: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		endpoint string
+		skipErr  error
+	}{
+		{name: "file", endpoint: "file:" + kekPath},
+		{name: "pkcs11", endpoint: "pkcs11:slot=0;object=kek", skipErr: backend.ErrNoHardwareToken},
+		{name: "awskms", endpoint: "awskms:arn:aws:kms:us-east-1:000000000000:key/test", skipErr: backend.ErrNoCloudCredentials},
+		{name: "gcpkms", endpoint: "gcpkms:projects/test/locations/global/keyRings/test/cryptoKeys/test", skipErr: backend.ErrNoCloudCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.skipErr != nil {
+				if _, err := backend.New(context.Background(), tt.endpoint); errors.Is(err, tt.skipErr) {
+					t.Skipf("skipping %s backend: %v", tt.name, err)
+				}
+			}
+
+			providerName := "kms-provider-" + tt.name
+			encryptionConfig := fmt.Sprintf(`
+kind: EncryptionConfiguration
+apiVersion: apiserver.config.k8s.io/v1
+resources:
+  - resources:
+    - secrets
+    providers:
+    - kms:
+       apiVersion: v2
+       name: %s
+       cachesize: 1000
+       endpoint: %s
+`, providerName, tt.endpoint)
+
+			test, err := newTransformTest(t, encryptionConfig)
+			if err != nil {
+				t.Fatalf("failed to start KUBE API Server with encryptionConfig\n %s, error: %v", encryptionConfig, err)
+			}
+			defer test.cleanUp()
+
+			test.secret, err = test.createSecret(testSecret, testNamespace)
+			if err != nil {
+				t.Fatalf("Failed to create test secret, error: %v", err)
+			}
+
+			rawEnvelope, err := test.getRawSecretFromETCD()
+			if err != nil {
+				t.Fatalf("failed to read secret from etcd: %v", err)
+			}
+			wantPrefix := (envelopekmsv2{providerName: providerName}).prefix()
+			if !bytes.HasPrefix(rawEnvelope, []byte(wantPrefix)) {
+				t.Fatalf("expected secret to be prefixed with %s, but got %s", wantPrefix, rawEnvelope)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			secretClient := test.restClient.CoreV1().Secrets(testNamespace)
+			s, err := secretClient.Get(ctx, testSecret, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get Secret from %s, err: %v", testNamespace, err)
+			}
+			if secretVal != string(s.Data[secretKey]) {
+				t.Fatalf("expected %s from KubeAPI, but got %s", secretVal, string(s.Data[secretKey]))
+			}
+		})
+	}
+}
+
+// TestKMSv2Metrics is an integration test between KubeAPI, ETCD and KMSv2 Plugin that
+// verifies the instrumentation added to envelopeTransformer and the gRPC service: after
+// a Secret write and read, /metrics should expose latency histograms for Encrypt,
+// Decrypt and Status labeled by provider name, and the DEK cache hit/miss counters.
+func TestKMSv2Metrics(t *testing.T) {
+	defer featuregatetesting.SetFeatureGateDuringTest(t, utilfeature.DefaultFeatureGate, features.KMSv2, true)()
+
+	encryptionConfig := `
+kind: EncryptionConfiguration
+apiVersion: apiserver.config.k8s.io/v1
+resources:
+  - resources:
+    - secrets
+    providers:
+    - kms:
+       apiVersion: v2
+       name: kms-provider
+       cachesize: 1000
+       endpoint: unix:///@kms-provider-metrics.sock
+`
+
+	providerName := "kms-provider"
+	pluginMock, err := kmsv2mock.NewBase64Plugin("@kms-provider-metrics.sock")
+	if err != nil {
+		t.Fatalf("failed to create mock of KMSv2 Plugin: %v", err)
+	}
+
+	go pluginMock.Start()
+	if err := kmsv2mock.WaitForBase64PluginToBeUp(pluginMock); err != nil {
+		t.Fatalf("Failed start plugin, err: %v", err)
+	}
+	defer pluginMock.CleanUp()
+
+	test, err := newTransformTest(t, encryptionConfig)
+	if err != nil {
+		t.Fatalf("failed to start KUBE API Server with encryptionConfig\n %s, error: %v", encryptionConfig, err)
+	}
+	defer test.cleanUp()
+
+	test.secret, err = test.createSecret(testSecret, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to create test secret, error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	secretClient := test.restClient.CoreV1().Secrets(testNamespace)
+	if _, err := secretClient.Get(ctx, testSecret, metav1.GetOptions{}); err != nil {
+		t.Fatalf("failed to get Secret from %s, err: %v", testNamespace, err)
+	}
+
+	metricsClient, err := rest.RESTClientFor(test.kubeAPIServer.ClientConfig)
+	if err != nil {
+		t.Fatalf("failed to build metrics client: %v", err)
+	}
+	body, err := metricsClient.Get().AbsPath("/metrics").DoRaw(ctx)
+	if err != nil {
+		t.Fatalf("failed to scrape /metrics: %v", err)
+	}
+
+	wantSeries := []string{
+		fmt.Sprintf(`apiserver_envelope_encryption_kms_operations_latency_seconds_count{operation="Encrypt",provider_name=%q`, providerName),
+		fmt.Sprintf(`apiserver_envelope_encryption_kms_operations_latency_seconds_count{operation="Decrypt",provider_name=%q`, providerName),
+		fmt.Sprintf(`apiserver_envelope_encryption_kms_operations_latency_seconds_count{operation="Status",provider_name=%q`, providerName),
+		fmt.Sprintf(`apiserver_envelope_encryption_dek_cache_total{provider_name=%q,result="hit"}`, providerName),
+		fmt.Sprintf(`apiserver_envelope_encryption_dek_cache_total{provider_name=%q,result="miss"}`, providerName),
+	}
+	for _, want := range wantSeries {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("expected /metrics to contain a series starting with %q, but it did not", want)
+		}
+	}
+}